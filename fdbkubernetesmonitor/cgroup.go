@@ -0,0 +1,67 @@
+// cgroup.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+// ResourceLimits describes the cgroup resource limits to apply to a single
+// supervised fdbserver process.
+type ResourceLimits struct {
+	// MemoryMaxBytes is the cgroup memory.max limit. A value of zero leaves
+	// memory unbounded.
+	MemoryMaxBytes int64 `json:"memoryMaxBytes,omitempty"`
+
+	// CPUMaxMicros is the cgroup cpu.max quota, in microseconds allowed per
+	// 100ms period. A value of zero leaves CPU unbounded.
+	CPUMaxMicros int64 `json:"cpuMaxMicros,omitempty"`
+
+	// IOMax is a raw cgroup io.max line, e.g. "8:0 rbps=2097152", applied
+	// as-is. An empty string leaves IO unbounded.
+	IOMax string `json:"ioMax,omitempty"`
+}
+
+// CgroupStats reports the resource usage and throttling a supervised
+// process experienced in its cgroup.
+type CgroupStats struct {
+	// MemoryUsageBytes is the last observed memory.current value.
+	MemoryUsageBytes int64
+
+	// CPUThrottledMicros is the cumulative throttled_usec from cpu.stat.
+	CPUThrottledMicros int64
+
+	// OOMKilled is true if memory.events reported an oom_kill for this
+	// cgroup.
+	OOMKilled bool
+}
+
+// processSupervisor places a single subprocess in its own cgroup so its
+// resource usage can be bounded and observed independently of any sibling
+// fdbserver processes sharing the same pod. Implementations are platform
+// specific; see cgroup_linux.go and cgroup_other.go.
+type processSupervisor interface {
+	// Attach moves pid into the supervised cgroup.
+	Attach(pid int) error
+
+	// Stats reads the current resource usage and throttling events for the
+	// supervised cgroup.
+	Stats() (CgroupStats, error)
+
+	// Close removes the supervised cgroup. It should be called once the
+	// supervised process has exited.
+	Close() error
+}
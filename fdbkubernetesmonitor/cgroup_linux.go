@@ -0,0 +1,193 @@
+//go:build linux
+
+// cgroup_linux.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is the parent slice under which every supervised fdbserver
+// process gets its own scope.
+const cgroupRoot = "/sys/fs/cgroup/foundationdb.slice"
+
+// cgroupFSRoot is the root of the cgroup v2 hierarchy.
+const cgroupFSRoot = "/sys/fs/cgroup"
+
+// controllersToEnable lists the cgroup v2 controllers a per-process scope
+// needs available in order to honor ResourceLimits. A controller only
+// produces control files (memory.max, cpu.max, io.max, ...) in a cgroup
+// once every ancestor cgroup between it and cgroupFSRoot has enabled that
+// controller for its children via cgroup.subtree_control.
+var controllersToEnable = []string{"memory", "cpu", "io"}
+
+// ensureControllersEnabled walks from cgroupFSRoot down to dir, creating
+// dir if needed and enabling controllersToEnable in every ancestor's
+// cgroup.subtree_control so they are available to dir's children.
+func ensureControllersEnabled(dir string) error {
+	if dir == cgroupFSRoot {
+		return enableControllerSubtree(dir)
+	}
+
+	parent := filepath.Dir(dir)
+	if err := ensureControllersEnabled(parent); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating cgroup %s: %w", dir, err)
+	}
+	return enableControllerSubtree(dir)
+}
+
+// enableControllerSubtree enables, via cgroup.subtree_control, every
+// controller in controllersToEnable that dir's own cgroup.controllers
+// reports as available. Re-enabling an already-enabled controller is a
+// harmless no-op, so this can be called unconditionally on every restart.
+func enableControllerSubtree(dir string) error {
+	available := make(map[string]bool)
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("error reading available cgroup controllers for %s: %w", dir, err)
+	}
+	for _, controller := range strings.Fields(string(data)) {
+		available[controller] = true
+	}
+
+	var toEnable []string
+	for _, controller := range controllersToEnable {
+		if available[controller] {
+			toEnable = append(toEnable, "+"+controller)
+		}
+	}
+	if len(toEnable) == 0 {
+		return nil
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(toEnable, " ")), 0644)
+	if err != nil {
+		return fmt.Errorf("error enabling cgroup controllers %v in %s: %w", toEnable, dir, err)
+	}
+	return nil
+}
+
+// linuxProcessSupervisor places a process into its own cgroup v2 scope
+// under cgroupRoot.
+type linuxProcessSupervisor struct {
+	scopePath string
+}
+
+// newProcessSupervisor creates a cgroup v2 scope for processNumber and
+// applies limits to it.
+func newProcessSupervisor(processNumber int, limits ResourceLimits) (processSupervisor, error) {
+	if err := ensureControllersEnabled(cgroupRoot); err != nil {
+		return nil, err
+	}
+
+	scopePath := filepath.Join(cgroupRoot, fmt.Sprintf("fdbserver-%d.scope", processNumber))
+	err := os.MkdirAll(scopePath, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cgroup %s: %w", scopePath, err)
+	}
+
+	supervisor := &linuxProcessSupervisor{scopePath: scopePath}
+
+	if limits.MemoryMaxBytes > 0 {
+		err = supervisor.writeControlFile("memory.max", strconv.FormatInt(limits.MemoryMaxBytes, 10))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limits.CPUMaxMicros > 0 {
+		err = supervisor.writeControlFile("cpu.max", fmt.Sprintf("%d 100000", limits.CPUMaxMicros))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limits.IOMax != "" {
+		err = supervisor.writeControlFile("io.max", limits.IOMax)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return supervisor, nil
+}
+
+// writeControlFile writes value to the named cgroup control file.
+func (supervisor *linuxProcessSupervisor) writeControlFile(name string, value string) error {
+	err := os.WriteFile(filepath.Join(supervisor.scopePath, name), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing cgroup control file %s: %w", name, err)
+	}
+	return nil
+}
+
+// Attach implements processSupervisor.
+func (supervisor *linuxProcessSupervisor) Attach(pid int) error {
+	return supervisor.writeControlFile("cgroup.procs", strconv.Itoa(pid))
+}
+
+// Stats implements processSupervisor.
+func (supervisor *linuxProcessSupervisor) Stats() (CgroupStats, error) {
+	stats := CgroupStats{}
+
+	memoryCurrent, err := os.ReadFile(filepath.Join(supervisor.scopePath, "memory.current"))
+	if err == nil {
+		stats.MemoryUsageBytes, _ = strconv.ParseInt(strings.TrimSpace(string(memoryCurrent)), 10, 64)
+	}
+
+	cpuStat, err := os.ReadFile(filepath.Join(supervisor.scopePath, "cpu.stat"))
+	if err == nil {
+		for _, line := range strings.Split(string(cpuStat), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "throttled_usec" {
+				stats.CPUThrottledMicros, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		}
+	}
+
+	memoryEvents, err := os.ReadFile(filepath.Join(supervisor.scopePath, "memory.events"))
+	if err == nil {
+		for _, line := range strings.Split(string(memoryEvents), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "oom_kill" {
+				count, _ := strconv.ParseInt(fields[1], 10, 64)
+				stats.OOMKilled = count > 0
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// Close implements processSupervisor.
+func (supervisor *linuxProcessSupervisor) Close() error {
+	err := os.Remove(supervisor.scopePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing cgroup %s: %w", supervisor.scopePath, err)
+	}
+	return nil
+}
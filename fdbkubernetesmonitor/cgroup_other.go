@@ -0,0 +1,46 @@
+//go:build !linux
+
+// cgroup_other.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+// stubProcessSupervisor is used on platforms without cgroup v2 support. It
+// accepts resource limits but does not enforce or report on them.
+type stubProcessSupervisor struct{}
+
+// newProcessSupervisor returns a no-op supervisor on non-Linux platforms.
+func newProcessSupervisor(_ int, _ ResourceLimits) (processSupervisor, error) {
+	return &stubProcessSupervisor{}, nil
+}
+
+// Attach implements processSupervisor.
+func (supervisor *stubProcessSupervisor) Attach(_ int) error {
+	return nil
+}
+
+// Stats implements processSupervisor.
+func (supervisor *stubProcessSupervisor) Stats() (CgroupStats, error) {
+	return CgroupStats{}, nil
+}
+
+// Close implements processSupervisor.
+func (supervisor *stubProcessSupervisor) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+// configfetcher.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// defaultConfigPollInterval is how often Monitor polls its ConfigFetcher
+// for a new configuration when no interval is otherwise specified.
+const defaultConfigPollInterval = 30 // seconds
+
+// ConfigFetcher retrieves the raw bytes of the monitor configuration from
+// some source, along with an opaque etag that changes whenever the
+// underlying configuration changes. Monitor only reloads the configuration
+// when the etag it receives differs from the last one it saw, so a
+// ConfigFetcher implementation that has nothing new to report can be
+// polled cheaply and often.
+type ConfigFetcher interface {
+	// Fetch retrieves the current configuration and its etag.
+	Fetch(ctx context.Context) (data []byte, etag string, err error)
+}
+
+// FileConfigFetcher reads the monitor configuration from a local file, the
+// same way the monitor has always worked. Its etag is a hash of the file
+// contents, since plain files do not carry a native etag.
+type FileConfigFetcher struct {
+	// Path is the path to the configuration file.
+	Path string
+}
+
+// Fetch implements ConfigFetcher.
+func (fetcher *FileConfigFetcher) Fetch(_ context.Context) ([]byte, string, error) {
+	data, err := os.ReadFile(fetcher.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hashETag(data), nil
+}
+
+// HTTPConfigFetcher polls a URL for the monitor configuration, using
+// If-None-Match/ETag headers so that repeated polls of an unchanged
+// configuration are cheap.
+type HTTPConfigFetcher struct {
+	// URL is the address to poll for the configuration.
+	URL string
+
+	// Client is the HTTP client used to make requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	lastETag string
+}
+
+// Fetch implements ConfigFetcher.
+func (fetcher *HTTPConfigFetcher) Fetch(ctx context.Context) ([]byte, string, error) {
+	client := fetcher.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fetcher.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if fetcher.lastETag != "" {
+		request.Header.Set("If-None-Match", fetcher.lastETag)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified {
+		return nil, fetcher.lastETag, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code %d fetching configuration from %s", response.StatusCode, fetcher.URL)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	etag := response.Header.Get("ETag")
+	if etag == "" {
+		etag = hashETag(data)
+	}
+	fetcher.lastETag = etag
+
+	return data, etag, nil
+}
+
+// ConfigMapConfigFetcher reads the monitor configuration out of a
+// Kubernetes ConfigMap key, using the in-cluster client that backs
+// PodClient. It lets a control plane push configuration changes by
+// updating the ConfigMap, instead of requiring a projected volume update
+// on every pod.
+type ConfigMapConfigFetcher struct {
+	// PodClient is used to read the ConfigMap through the in-cluster API
+	// server connection.
+	PodClient *PodClient
+
+	// Key is the key within the ConfigMap that holds the monitor
+	// configuration.
+	Key string
+}
+
+// Fetch implements ConfigFetcher.
+func (fetcher *ConfigMapConfigFetcher) Fetch(ctx context.Context) ([]byte, string, error) {
+	data, resourceVersion, err := fetcher.PodClient.GetConfigMapData(ctx, fetcher.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	return []byte(data), resourceVersion, nil
+}
+
+// hashETag derives an etag for a ConfigFetcher implementation that has no
+// native versioning of its own.
+func hashETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
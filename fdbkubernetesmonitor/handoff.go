@@ -0,0 +1,220 @@
+// handoff.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// bindListenerFile binds a TCP listener on address and returns it as an
+// *os.File suitable for exec.Cmd.ExtraFiles. The monitor keeps this file
+// for the entire lifetime of a process slot and passes a copy of its
+// descriptor to every incarnation of the subprocess that occupies that
+// slot (the initial start and every subsequent graceful handoff), the way
+// a systemd-style socket-activating supervisor owns the listening socket
+// rather than the service it starts. Because the descriptor lives in the
+// monitor's own process, handing it to a replacement subprocess is a plain
+// fork+exec inheritance rather than an IPC transfer between two unrelated
+// processes.
+func bindListenerFile(address string) (*os.File, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error binding listener on %s: %w", address, err)
+	}
+	defer listener.Close()
+
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("listener on %s was not a TCP listener", address)
+	}
+
+	// File() duplicates the listener's underlying descriptor. Closing the
+	// original listener above leaves this duplicate open and still bound.
+	file, err := tcpListener.File()
+	if err != nil {
+		return nil, fmt.Errorf("error duplicating listener descriptor for %s: %w", address, err)
+	}
+
+	return file, nil
+}
+
+// handoffReadyTimeout bounds how long Monitor waits for a freshly forked
+// fdbserver child to report readiness during a graceful binary handoff
+// before rolling back and leaving the original process in place.
+const handoffReadyTimeout = 30 * time.Second
+
+// handoffReadySocketEnvVar names the environment variable that tells a
+// handoff child where to dial once it has bound its listening sockets and
+// is ready to take over from the process it is replacing.
+const handoffReadySocketEnvVar = "FDB_MONITOR_HANDOFF_READY_SOCKET"
+
+// handoffListenFDsEnvVar mirrors systemd's LISTEN_FDS convention: it tells
+// the child how many of its inherited file descriptors (starting at fd 3,
+// i.e. the first entry in ExtraFiles) are listening sockets passed down
+// by the monitor, which holds them for the process slot (see
+// bindListenerFile).
+const handoffListenFDsEnvVar = "FDB_MONITOR_HANDOFF_LISTEN_FDS"
+
+// handoffResult carries the outcome of an attempted graceful binary swap
+// for a single process slot.
+type handoffResult struct {
+	// cmd is the replacement process, set only when the handoff succeeded.
+	cmd *exec.Cmd
+
+	// pid is the PID of the replacement process, set only when the handoff
+	// succeeded.
+	pid int
+
+	// stdout and stderr are the replacement process's output pipes, opened
+	// before the process was started, so the caller can attach the same log
+	// scanners it uses for every other subprocess.
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+
+	// waitErr is the channel that will receive cmd.Wait()'s result. A
+	// single background goroutine, started by performHandoff, is the only
+	// caller of cmd.Wait(); the caller of performHandoff must use this
+	// channel instead of calling cmd.Wait() itself, since Wait may only be
+	// called once per process.
+	waitErr <-chan error
+
+	// err is set when the handoff failed and the caller should leave the
+	// old process running unmodified.
+	err error
+}
+
+// performHandoff forks a replacement fdbserver process for processNumber,
+// passing along listenerFile (the listening socket the monitor has held
+// for this process slot since it was first started, or nil if none could
+// be bound), and waits for the replacement to signal readiness. If the
+// replacement becomes ready within handoffReadyTimeout, the old process is
+// sent SIGTERM and the new command is returned so the caller can start
+// supervising it in place of the old one. If the replacement fails to
+// start, exits early, or never signals readiness, the old process is left
+// untouched and the replacement (if any) is killed.
+func (monitor *Monitor) performHandoff(processNumber int, oldPID int, listenerFile *os.File, logger logr.Logger) handoffResult {
+	arguments, err := monitor.ActiveConfiguration.GenerateArguments(processNumber, nil)
+	if err != nil {
+		return handoffResult{err: fmt.Errorf("error generating arguments for handoff: %w", err)}
+	}
+
+	var extraFiles []*os.File
+	if listenerFile != nil {
+		extraFiles = []*os.File{listenerFile}
+	}
+
+	readySocketPath := fmt.Sprintf("/tmp/fdb-monitor-handoff-%d-%d.sock", processNumber, time.Now().UnixNano())
+
+	cmd := &exec.Cmd{
+		Path:       arguments[0],
+		Args:       arguments,
+		ExtraFiles: extraFiles,
+		Env: append(os.Environ(),
+			fmt.Sprintf("%s=%s", handoffReadySocketEnvVar, readySocketPath),
+			fmt.Sprintf("%s=%s", handoffListenFDsEnvVar, strconv.Itoa(len(extraFiles))),
+		),
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		logger.Error(err, "Error getting stdout from replacement subprocess")
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		logger.Error(err, "Error getting stderr from replacement subprocess")
+	}
+
+	logger.Info("Starting replacement subprocess for graceful handoff", "arguments", arguments, "inheritedListeners", len(extraFiles))
+
+	err = cmd.Start()
+	if err != nil {
+		return handoffResult{err: fmt.Errorf("error starting replacement subprocess: %w", err)}
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	err = waitForHandoffReady(readySocketPath, handoffReadyTimeout, exited)
+	if err != nil {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+		return handoffResult{err: fmt.Errorf("replacement subprocess did not become ready: %w", err)}
+	}
+
+	logger.Info("Replacement subprocess is ready; terminating previous subprocess", "PID", oldPID, "replacementPID", cmd.Process.Pid)
+	err = signalProcess(oldPID)
+	if err != nil {
+		logger.Error(err, "Error signaling previous subprocess after handoff", "PID", oldPID)
+	}
+
+	return handoffResult{cmd: cmd, pid: cmd.Process.Pid, stdout: stdout, stderr: stderr, waitErr: exited}
+}
+
+// waitForHandoffReady blocks on a unix socket at socketPath until the
+// replacement process dials in to signal readiness, the deadline elapses,
+// or the replacement process exits first.
+func waitForHandoffReady(socketPath string, deadline time.Duration, exited <-chan error) error {
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	defer os.Remove(socketPath)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		return nil
+	case err := <-exited:
+		return fmt.Errorf("replacement process exited before signaling readiness: %w", err)
+	case <-time.After(deadline):
+		return fmt.Errorf("timed out after %s waiting for replacement process to signal readiness", deadline)
+	}
+}
+
+// signalProcess sends SIGTERM to pid without waiting for it to exit; the
+// caller's run loop is expected to observe the exit through its normal
+// cmd.Wait() path.
+func signalProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(syscall.SIGTERM)
+}
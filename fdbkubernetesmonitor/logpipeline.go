@@ -0,0 +1,229 @@
+// logpipeline.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// logEventRateLimitWindow is the sliding window used to rate-limit how
+// often a given event type is forwarded to the Kubernetes API server.
+const logEventRateLimitWindow = time.Minute
+
+// logEventRateLimit is the maximum number of times a single event type is
+// forwarded within logEventRateLimitWindow. Additional occurrences are
+// still logged, just not forwarded as pod events/annotations.
+const logEventRateLimit = 10
+
+// logEventSeverities lists the fdbserver trace severities, from the C++
+// Severity enum, that should be logged at error level rather than info.
+var logEventSeverities = map[string]bool{
+	"SevError":      true,
+	"SevWarnAlways": true,
+}
+
+// logEventSignificantTypes lists trace event Type values that are always
+// worth surfacing as a pod annotation/event, regardless of severity.
+var logEventSignificantTypes = map[string]bool{
+	"RoleChange": true,
+	"Role":       true,
+}
+
+// LogEvent is a single parsed fdbserver trace line.
+type LogEvent struct {
+	// Severity is the trace event's Severity field, e.g. "SevError" or
+	// "SevInfo".
+	Severity string
+
+	// Type is the trace event's Type field, e.g. "RoleChange" or
+	// "ProcessCrashed".
+	Type string
+
+	// Machine is the trace event's Machine field.
+	Machine string
+
+	// Roles is the trace event's Roles field.
+	Roles string
+
+	// Fields holds every field from the parsed trace line, including
+	// Severity/Type/Machine/Roles.
+	Fields map[string]interface{}
+
+	// Raw is the original, unparsed line. parseLogEvent never sets it: a
+	// line that is not JSON yields an error and no LogEvent.
+	Raw string
+}
+
+// LogPipeline parses fdbserver's stdout/stderr output, forwarding
+// structured trace events to the monitor's logger and, for significant
+// events, to the Kubernetes API server through PodClient.
+type LogPipeline struct {
+	// logger is the destination for every parsed or raw line.
+	logger logr.Logger
+
+	// podClient is used to forward significant events as pod
+	// annotations/events. It may be nil in tests.
+	podClient *PodClient
+
+	// fieldAllowlist restricts which trace fields are promoted into the
+	// logger's key/value pairs. A nil or empty allowlist promotes every
+	// field.
+	fieldAllowlist map[string]bool
+
+	// mutex guards windowStart and eventCounts.
+	mutex sync.Mutex
+
+	// windowStart is the start of the current rate-limiting window.
+	windowStart time.Time
+
+	// eventCounts tracks how many times each event type has been
+	// forwarded during the current window.
+	eventCounts map[string]int
+}
+
+// NewLogPipeline creates a LogPipeline that logs through logger and
+// forwards significant events through podClient. An empty allowlist
+// promotes every field from a parsed trace event into the logger output.
+func NewLogPipeline(logger logr.Logger, podClient *PodClient, allowlist []string) *LogPipeline {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, field := range allowlist {
+		allowed[field] = true
+	}
+
+	return &LogPipeline{
+		logger:         logger,
+		podClient:      podClient,
+		fieldAllowlist: allowed,
+		windowStart:    time.Now(),
+		eventCounts:    make(map[string]int),
+	}
+}
+
+// parseLogEvent attempts to decode line as a JSON fdbserver trace event.
+func parseLogEvent(line string) (*LogEvent, error) {
+	fields := make(map[string]interface{})
+	err := json.Unmarshal([]byte(line), &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &LogEvent{Fields: fields}
+	event.Severity, _ = fields["Severity"].(string)
+	event.Type, _ = fields["Type"].(string)
+	event.Machine, _ = fields["Machine"].(string)
+	event.Roles, _ = fields["Roles"].(string)
+
+	return event, nil
+}
+
+// SetFieldAllowlist replaces the set of trace fields that are promoted into
+// the logger's key/value pairs. An empty allowlist promotes every field.
+func (pipeline *LogPipeline) SetFieldAllowlist(fields []string) {
+	allowed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		allowed[field] = true
+	}
+
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	pipeline.fieldAllowlist = allowed
+}
+
+// HandleLine processes a single line of output from process pid. Lines
+// that parse as a JSON trace event are logged with their fields promoted
+// into structured key/value pairs and, if significant, forwarded through
+// PodClient. Lines that are not valid JSON fall back to being logged
+// verbatim, the same way raw subprocess output has always been handled.
+func (pipeline *LogPipeline) HandleLine(line string, pid int, isError bool) {
+	event, err := parseLogEvent(line)
+	if err != nil {
+		if isError {
+			pipeline.logger.Error(nil, "Subprocess error log", "msg", line, "PID", pid)
+		} else {
+			pipeline.logger.Info("Subprocess output", "msg", line, "PID", pid)
+		}
+		return
+	}
+
+	pipeline.mutex.Lock()
+	allowlist := pipeline.fieldAllowlist
+	pipeline.mutex.Unlock()
+
+	keysAndValues := make([]interface{}, 0, len(event.Fields)*2+2)
+	keysAndValues = append(keysAndValues, "PID", pid)
+	for key, value := range event.Fields {
+		if len(allowlist) > 0 && !allowlist[key] {
+			continue
+		}
+		keysAndValues = append(keysAndValues, key, value)
+	}
+
+	if logEventSeverities[event.Severity] {
+		pipeline.logger.Error(nil, "Subprocess trace event", keysAndValues...)
+	} else {
+		pipeline.logger.Info("Subprocess trace event", keysAndValues...)
+	}
+
+	if pipeline.isSignificant(event) && pipeline.admitForForwarding(event) {
+		pipeline.forward(event, pid)
+	}
+}
+
+// isSignificant returns whether event is the kind of trace event that
+// should be surfaced to the Kubernetes API server rather than left in the
+// pod's logs.
+func (pipeline *LogPipeline) isSignificant(event *LogEvent) bool {
+	return event.Severity == "SevError" || logEventSignificantTypes[event.Type]
+}
+
+// admitForForwarding applies the rate limit for forwarded events, so a
+// single noisy event type cannot flood the Kubernetes API server.
+func (pipeline *LogPipeline) admitForForwarding(event *LogEvent) bool {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+
+	if time.Since(pipeline.windowStart) > logEventRateLimitWindow {
+		pipeline.windowStart = time.Now()
+		pipeline.eventCounts = make(map[string]int)
+	}
+
+	pipeline.eventCounts[event.Type]++
+	return pipeline.eventCounts[event.Type] <= logEventRateLimit
+}
+
+// forward reports a significant event through PodClient, so an operator
+// watching the pod with `kubectl describe` or `kubectl get events` can see
+// it without tailing the container's logs.
+func (pipeline *LogPipeline) forward(event *LogEvent, pid int) {
+	if pipeline.podClient == nil {
+		return
+	}
+
+	message := fmt.Sprintf("fdbserver (PID %d) reported %s: %s", pid, event.Type, event.Roles)
+	err := pipeline.podClient.PublishEvent(event.Type, message, event.Severity == "SevError")
+	if err != nil {
+		pipeline.logger.Error(err, "Error forwarding subprocess event", "eventType", event.Type, "PID", pid)
+	}
+}
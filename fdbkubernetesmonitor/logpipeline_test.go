@@ -0,0 +1,131 @@
+// logpipeline_test.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseLogEvent(t *testing.T) {
+	event, err := parseLogEvent(`{"Severity":"SevError","Type":"RoleChange","Machine":"1.2.3.4:4500","Roles":"SS"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.Severity != "SevError" || event.Type != "RoleChange" || event.Machine != "1.2.3.4:4500" || event.Roles != "SS" {
+		t.Fatalf("unexpected parsed event: %+v", event)
+	}
+}
+
+func TestParseLogEventRejectsNonJSON(t *testing.T) {
+	_, err := parseLogEvent("this is not json")
+	if err == nil {
+		t.Fatalf("expected an error for a non-JSON line")
+	}
+}
+
+func TestHandleLineFallsBackToRawOnNonJSON(t *testing.T) {
+	pipeline := NewLogPipeline(logr.Discard(), nil, nil)
+	// A raw, non-JSON line should be handled without panicking, even though
+	// it cannot be logged as a structured trace event.
+	pipeline.HandleLine("plain text output", 123, false)
+	pipeline.HandleLine("plain text error", 123, true)
+}
+
+func TestIsSignificant(t *testing.T) {
+	tests := map[string]struct {
+		event *LogEvent
+		want  bool
+	}{
+		"error severity":         {event: &LogEvent{Severity: "SevError"}, want: true},
+		"significant type":       {event: &LogEvent{Severity: "SevInfo", Type: "RoleChange"}, want: true},
+		"ordinary info event":    {event: &LogEvent{Severity: "SevInfo", Type: "Ping"}, want: false},
+		"warn-always is ignored": {event: &LogEvent{Severity: "SevWarnAlways", Type: "Ping"}, want: false},
+	}
+
+	pipeline := NewLogPipeline(logr.Discard(), nil, nil)
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := pipeline.isSignificant(test.event); got != test.want {
+				t.Fatalf("isSignificant() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAdmitForForwardingRateLimitsPerEventType(t *testing.T) {
+	pipeline := NewLogPipeline(logr.Discard(), nil, nil)
+	event := &LogEvent{Type: "RoleChange"}
+
+	for i := 0; i < logEventRateLimit; i++ {
+		if !pipeline.admitForForwarding(event) {
+			t.Fatalf("expected occurrence %d to be admitted within the rate limit", i+1)
+		}
+	}
+
+	if pipeline.admitForForwarding(event) {
+		t.Fatalf("expected an occurrence beyond logEventRateLimit to be rejected")
+	}
+
+	// A different event type has its own independent counter.
+	if !pipeline.admitForForwarding(&LogEvent{Type: "ProcessCrashed"}) {
+		t.Fatalf("expected a different event type to have its own rate limit budget")
+	}
+}
+
+func TestAdmitForForwardingResetsAfterWindow(t *testing.T) {
+	pipeline := NewLogPipeline(logr.Discard(), nil, nil)
+	event := &LogEvent{Type: "RoleChange"}
+
+	for i := 0; i < logEventRateLimit; i++ {
+		pipeline.admitForForwarding(event)
+	}
+	if pipeline.admitForForwarding(event) {
+		t.Fatalf("expected the rate limit to be exhausted before the window resets")
+	}
+
+	pipeline.windowStart = pipeline.windowStart.Add(-(logEventRateLimitWindow + 1))
+	if !pipeline.admitForForwarding(event) {
+		t.Fatalf("expected the rate limit to reset once the window has elapsed")
+	}
+}
+
+func TestForwardIsNoOpWithNilPodClient(t *testing.T) {
+	pipeline := NewLogPipeline(logr.Discard(), nil, nil)
+	// podClient is nil, as the LogPipeline.podClient field comment notes
+	// is expected in tests; forward must tolerate that rather than panicking.
+	pipeline.forward(&LogEvent{Type: "RoleChange", Roles: "SS"}, 123)
+}
+
+func TestSetFieldAllowlistReplacesPreviousAllowlist(t *testing.T) {
+	pipeline := NewLogPipeline(logr.Discard(), nil, []string{"Severity"})
+
+	pipeline.SetFieldAllowlist([]string{"Type", "Roles"})
+
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	if pipeline.fieldAllowlist["Severity"] {
+		t.Fatalf("expected the old allowlist entry to be replaced, not merged")
+	}
+	if !pipeline.fieldAllowlist["Type"] || !pipeline.fieldAllowlist["Roles"] {
+		t.Fatalf("expected the new allowlist entries to be present")
+	}
+}
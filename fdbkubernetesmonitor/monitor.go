@@ -21,7 +21,10 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -66,6 +69,24 @@ type Monitor struct {
 	// the subprocess.
 	ProcessIDs []int
 
+	// ProcessBinaries tracks the binary path that is currently backing each
+	// running process slot, so LoadConfiguration can tell when a slot needs
+	// a graceful handoff to a new binary rather than a plain restart.
+	ProcessBinaries []string
+
+	// HandoffSignals delivers a notification to RunProcess when the binary
+	// for a process has changed underneath it, so it can perform a graceful
+	// zero-downtime handoff instead of waiting for the old process to exit.
+	HandoffSignals []chan bool
+
+	// RestartPolicies tracks the exponential backoff and crash-loop state
+	// for each process slot.
+	RestartPolicies []*RestartPolicy
+
+	// ProcessResourceStats holds the most recently observed cgroup resource
+	// usage for each process slot.
+	ProcessResourceStats []CgroupStats
+
 	// Mutex defines a mutex around working with configuration.
 	Mutex sync.Mutex
 
@@ -73,42 +94,117 @@ type Monitor struct {
 	// Kubernetes.
 	PodClient *PodClient
 
+	// LogPipeline parses structured trace output from the fdbserver
+	// subprocesses and forwards significant events through PodClient.
+	LogPipeline *LogPipeline
+
+	// ConfigFetcher is the source Monitor loads its configuration from. It
+	// is fetched on every fsnotify event on ConfigFile and polled every
+	// ConfigPollInterval. It may be nil, in which case the configuration is
+	// read from ConfigFile and only the file watch is used.
+	ConfigFetcher ConfigFetcher
+
+	// ConfigPollInterval controls how often ConfigFetcher is polled.
+	ConfigPollInterval time.Duration
+
+	// lastConfigETag is the etag of the configuration ConfigFetcher most
+	// recently reported, used to detect when a poll has nothing new.
+	lastConfigETag string
+
+	// Paused is set by SIGUSR2 to stop RunProcess from restarting crashed
+	// processes, so an operator can inspect a crashing fdbserver in place.
+	Paused bool
+
+	// unpauseSignal is closed and replaced whenever Paused transitions back
+	// to false, waking any RunProcess goroutine blocked on a pause.
+	unpauseSignal chan struct{}
+
+	// RollingRestartActive is set while a SIGUSR1-triggered rolling
+	// restart is in progress.
+	RollingRestartActive bool
+
+	// RollingRestartProcessNumber is the process slot currently being
+	// drained, when RollingRestartActive is true.
+	RollingRestartProcessNumber int
+
 	// Logger is the logger instance for this monitor.
 	Logger logr.Logger
 }
 
 // StartMonitor starts the monitor loop.
-func StartMonitor(logger logr.Logger, configFile string, fdbserverPath string) {
+func StartMonitor(logger logr.Logger, configFile string, fdbserverPath string, fetcher ConfigFetcher, pollInterval time.Duration) {
 	podClient, err := CreatePodClient()
 	if err != nil {
 		panic(err)
 	}
 
+	if fetcher == nil {
+		fetcher = &FileConfigFetcher{Path: configFile}
+	}
+	if pollInterval <= 0 {
+		pollInterval = defaultConfigPollInterval * time.Second
+	}
+
 	monitor := &Monitor{
-		ConfigFile:    configFile,
-		FDBServerPath: fdbserverPath,
-		PodClient:     podClient,
-		Logger:        logger,
+		ConfigFile:         configFile,
+		FDBServerPath:      fdbserverPath,
+		PodClient:          podClient,
+		Logger:             logger,
+		ConfigFetcher:      fetcher,
+		ConfigPollInterval: pollInterval,
+		unpauseSignal:      make(chan struct{}),
 	}
+	monitor.LogPipeline = NewLogPipeline(logger, podClient, nil)
 
 	go func() { monitor.WatchPodTimestamps() }()
 	monitor.Run()
 }
 
-// LoadConfiguration loads the latest configuration from the config file.
+// LoadConfiguration loads the latest configuration through
+// monitor.ConfigFetcher. It shares reloadConfiguration with
+// PollConfiguration so both paths keep lastConfigETag in sync; without
+// that, a reload triggered here (by fsnotify, SIGHUP, or a pod-timestamp
+// bump) would leave lastConfigETag stale and cause PollConfiguration's next
+// tick to redundantly reapply the same configuration it just saw.
 func (monitor *Monitor) LoadConfiguration() {
-	file, err := os.Open(monitor.ConfigFile)
+	monitor.reloadConfiguration()
+}
+
+// reloadConfiguration fetches the current configuration through
+// monitor.ConfigFetcher (or from ConfigFile when that is nil) and applies it
+// only if its etag differs from the last one seen, recording the new etag
+// either way so callers racing on the same unchanged configuration only
+// apply it once between them.
+func (monitor *Monitor) reloadConfiguration() {
+	fetcher := monitor.ConfigFetcher
+	if fetcher == nil {
+		fetcher = &FileConfigFetcher{Path: monitor.ConfigFile}
+	}
+
+	data, etag, err := fetcher.Fetch(context.Background())
 	if err != nil {
-		monitor.Logger.Error(err, "Error reading monitor config file", "monitorConfigPath", monitor.ConfigFile)
+		monitor.Logger.Error(err, "Error fetching configuration")
 		return
 	}
-	defer file.Close()
-	configuration := &ProcessConfiguration{}
-	configurationBytes, err := io.ReadAll(file)
-	if err != nil {
-		monitor.Logger.Error(err, "Error reading monitor configuration", "monitorConfigPath", monitor.ConfigFile)
+
+	monitor.Mutex.Lock()
+	if etag == monitor.lastConfigETag {
+		monitor.Mutex.Unlock()
+		return
 	}
-	err = json.Unmarshal(configurationBytes, configuration)
+	monitor.lastConfigETag = etag
+	monitor.Mutex.Unlock()
+
+	monitor.applyConfiguration(data)
+}
+
+// applyConfiguration parses configurationBytes and, if it is a valid
+// configuration, makes it the active configuration, starting or handing
+// off processes as needed. This is the common path shared by file-watch
+// based reloads and ConfigFetcher-driven polling.
+func (monitor *Monitor) applyConfiguration(configurationBytes []byte) {
+	configuration := &ProcessConfiguration{}
+	err := json.Unmarshal(configurationBytes, configuration)
 	if err != nil {
 		monitor.Logger.Error(err, "Error parsing monitor configuration", "rawConfiguration", string(configurationBytes))
 		return
@@ -142,21 +238,52 @@ func (monitor *Monitor) LoadConfiguration() {
 
 	if monitor.ProcessIDs == nil {
 		monitor.ProcessIDs = make([]int, configuration.ServerCount+1)
+		monitor.ProcessBinaries = make([]string, configuration.ServerCount+1)
+		monitor.HandoffSignals = make([]chan bool, configuration.ServerCount+1)
+		monitor.RestartPolicies = make([]*RestartPolicy, configuration.ServerCount+1)
+		monitor.ProcessResourceStats = make([]CgroupStats, configuration.ServerCount+1)
 	} else {
 		for len(monitor.ProcessIDs) <= configuration.ServerCount {
 			monitor.ProcessIDs = append(monitor.ProcessIDs, 0)
+			monitor.ProcessBinaries = append(monitor.ProcessBinaries, "")
+			monitor.HandoffSignals = append(monitor.HandoffSignals, nil)
+			monitor.RestartPolicies = append(monitor.RestartPolicies, nil)
+			monitor.ProcessResourceStats = append(monitor.ProcessResourceStats, CgroupStats{})
+		}
+	}
+
+	// Only clear crash-loop/backoff state when the configuration actually
+	// changed. applyConfiguration is reached whenever the fetcher's etag
+	// changes, and an etag can change while the content stays the same (an
+	// HTTP server issuing a new ETag, a ConfigMap resourceVersion bump);
+	// resetting every policy on those no-op reloads would defeat the
+	// crash-loop protection.
+	configurationChanged := !bytes.Equal(monitor.ActiveConfigurationBytes, configurationBytes)
+	if configurationChanged {
+		for processNumber := 1; processNumber <= configuration.ServerCount; processNumber++ {
+			if monitor.RestartPolicies[processNumber] != nil {
+				monitor.RestartPolicies[processNumber].Reset()
+			}
 		}
 	}
 
 	monitor.ActiveConfiguration = configuration
 	monitor.ActiveConfigurationBytes = configurationBytes
 	monitor.LastConfigurationTime = time.Now()
+	monitor.LogPipeline.SetFieldAllowlist(configuration.LogFieldAllowlist)
 
 	for processNumber := 1; processNumber <= configuration.ServerCount; processNumber++ {
 		if monitor.ProcessIDs[processNumber] == 0 {
 			monitor.ProcessIDs[processNumber] = -1
+			monitor.HandoffSignals[processNumber] = make(chan bool, 1)
+			monitor.RestartPolicies[processNumber] = NewRestartPolicy()
 			tempNumber := processNumber
 			go func() { monitor.RunProcess(tempNumber) }()
+		} else if monitor.ProcessIDs[processNumber] > 0 && monitor.ProcessBinaries[processNumber] != "" && monitor.ProcessBinaries[processNumber] != configuration.BinaryPath {
+			select {
+			case monitor.HandoffSignals[processNumber] <- true:
+			default:
+			}
 		}
 	}
 
@@ -166,29 +293,97 @@ func (monitor *Monitor) LoadConfiguration() {
 	}
 }
 
+// watchSubprocessOutput attaches scanners to a subprocess's stdout and
+// stderr pipes that feed each line through pipeline, tagged with its PID.
+func watchSubprocessOutput(pipeline *LogPipeline, stdout io.ReadCloser, stderr io.ReadCloser, pid int) {
+	if stdout != nil {
+		stdoutScanner := bufio.NewScanner(stdout)
+		go func() {
+			for stdoutScanner.Scan() {
+				pipeline.HandleLine(stdoutScanner.Text(), pid, false)
+			}
+		}()
+	}
+
+	if stderr != nil {
+		stderrScanner := bufio.NewScanner(stderr)
+		go func() {
+			for stderrScanner.Scan() {
+				pipeline.HandleLine(stderrScanner.Text(), pid, true)
+			}
+		}()
+	}
+}
+
 // RunProcess runs a loop to continually start and watch a process.
 func (monitor *Monitor) RunProcess(processNumber int) {
 	pid := 0
 	logger := monitor.Logger.WithValues("processNumber", processNumber, "area", "RunProcess")
 	logger.Info("Starting run loop")
+	policy := monitor.RestartPolicies[processNumber]
+
+	// listenerFile is the listening socket the monitor binds itself and
+	// keeps open for as long as this process slot exists, so that a
+	// graceful binary handoff can hand the same bound socket down to the
+	// replacement subprocess via ExtraFiles instead of needing to recover
+	// it from the outgoing subprocess after the fact.
+	var listenerFile *os.File
+
 	for {
 		monitor.Mutex.Lock()
 		if monitor.ActiveConfiguration.ServerCount < processNumber {
 			logger.Info("Terminating run loop")
 			monitor.ProcessIDs[processNumber] = 0
 			monitor.Mutex.Unlock()
+			if listenerFile != nil {
+				listenerFile.Close()
+			}
 			return
 		}
 		monitor.Mutex.Unlock()
 
+		if policy.State() == restartStateCrashLoop {
+			logger.Info("Process is crash-looping; restarts are suspended")
+			err := monitor.PodClient.UpdateAnnotations(monitor)
+			if err != nil {
+				logger.Error(err, "Error updating pod annotations")
+			}
+			policy.WaitForRelease()
+			logger.Info("Crash loop cleared; resuming restarts")
+		}
+
+		monitor.waitWhilePaused(logger)
+
 		arguments, err := monitor.ActiveConfiguration.GenerateArguments(processNumber, nil)
 		if err != nil {
 			logger.Error(err, "Error generating arguments for subprocess", "configuration", monitor.ActiveConfiguration)
 			time.Sleep(errorBackoffSeconds * time.Second)
 		}
-		cmd := exec.Cmd{
-			Path: arguments[0],
-			Args: arguments,
+		if listenerFile == nil {
+			address, addrErr := publicAddressFromArguments(arguments)
+			if addrErr != nil {
+				logger.Error(addrErr, "Could not determine listening address for subprocess; handoffs will bind a fresh socket")
+			} else {
+				listenerFile, err = bindListenerFile(address)
+				if err != nil {
+					logger.Error(err, "Error binding listening socket for subprocess; handoffs will bind a fresh socket")
+					listenerFile = nil
+				}
+			}
+		}
+
+		var extraFiles []*os.File
+		if listenerFile != nil {
+			extraFiles = []*os.File{listenerFile}
+		}
+
+		cmd := &exec.Cmd{
+			Path:       arguments[0],
+			Args:       arguments,
+			ExtraFiles: extraFiles,
+		}
+		if listenerFile != nil {
+			cmd.Env = append(os.Environ(), fmt.Sprintf("%s=1", handoffListenFDsEnvVar))
 		}
 
 		logger.Info("Starting subprocess", "arguments", arguments)
@@ -221,27 +416,62 @@ func (monitor *Monitor) RunProcess(processNumber int) {
 
 		monitor.Mutex.Lock()
 		monitor.ProcessIDs[processNumber] = pid
+		monitor.ProcessBinaries[processNumber] = arguments[0]
 		monitor.Mutex.Unlock()
 
-		if stdout != nil {
-			stdoutScanner := bufio.NewScanner(stdout)
-			go func() {
-				for stdoutScanner.Scan() {
-					logger.Info("Subprocess output", "msg", stdoutScanner.Text(), "PID", pid)
-				}
-			}()
+		supervisor, err := newProcessSupervisor(processNumber, monitor.ActiveConfiguration.ResourceLimits)
+		if err != nil {
+			logger.Error(err, "Error creating cgroup supervisor for subprocess")
+		} else if err := supervisor.Attach(pid); err != nil {
+			logger.Error(err, "Error attaching subprocess to cgroup")
 		}
 
-		if stderr != nil {
-			stderrScanner := bufio.NewScanner(stderr)
-			go func() {
-				for stderrScanner.Scan() {
-					logger.Error(nil, "Subprocess error log", "msg", stderrScanner.Text(), "PID", pid)
+		watchSubprocessOutput(monitor.LogPipeline, stdout, stderr, pid)
+		policy.MarkStarted()
+		go monitor.probeUntilReady(policy, arguments, logger)
+
+		waitChan := make(chan error, 1)
+		go func() { waitChan <- cmd.Wait() }()
+		var waitErr <-chan error = waitChan
+
+	waitLoop:
+		for {
+			select {
+			case err = <-waitErr:
+				break waitLoop
+			case <-monitor.HandoffSignals[processNumber]:
+				result := monitor.performHandoff(processNumber, pid, listenerFile, logger)
+				if result.err != nil {
+					logger.Error(result.err, "Graceful binary handoff failed; keeping previous subprocess running", "PID", pid)
+					continue waitLoop
 				}
-			}()
+
+				logger.Info("Graceful binary handoff complete; now supervising replacement subprocess", "previousPID", pid, "PID", result.pid)
+				<-waitErr // the previous subprocess was signaled by performHandoff; drain its exit.
+
+				pid = result.pid
+				cmd = result.cmd
+				startTime = time.Now()
+				if supervisor != nil {
+					if err := supervisor.Attach(pid); err != nil {
+						logger.Error(err, "Error attaching replacement subprocess to cgroup")
+					}
+				}
+				watchSubprocessOutput(monitor.LogPipeline, result.stdout, result.stderr, pid)
+
+				monitor.Mutex.Lock()
+				monitor.ProcessIDs[processNumber] = pid
+				monitor.ProcessBinaries[processNumber] = monitor.ActiveConfiguration.BinaryPath
+				monitor.Mutex.Unlock()
+
+				// result.waitErr already has performHandoff's own
+				// goroutine waiting on the replacement's cmd.Wait(); reuse
+				// it instead of starting a second waiter on the same
+				// *exec.Cmd, which is undefined behavior.
+				waitErr = result.waitErr
+			}
 		}
 
-		err = cmd.Wait()
 		if err != nil {
 			logger.Error(err, "Error from subprocess", "PID", pid)
 		}
@@ -252,16 +482,60 @@ func (monitor *Monitor) RunProcess(processNumber int) {
 
 		logger.Info("Subprocess terminated", "exitCode", exitCode, "PID", pid)
 
+		oomKilled := false
+		if supervisor != nil {
+			stats, statErr := supervisor.Stats()
+			if statErr != nil {
+				logger.Error(statErr, "Error reading cgroup stats for subprocess")
+			} else {
+				logger.Info("Subprocess resource usage", "memoryUsageBytes", stats.MemoryUsageBytes, "cpuThrottledMicros", stats.CPUThrottledMicros, "oomKilled", stats.OOMKilled)
+				oomKilled = stats.OOMKilled
+				monitor.Mutex.Lock()
+				monitor.ProcessResourceStats[processNumber] = stats
+				monitor.Mutex.Unlock()
+			}
+			if closeErr := supervisor.Close(); closeErr != nil {
+				logger.Error(closeErr, "Error closing cgroup supervisor for subprocess")
+			}
+		}
+
 		endTime := time.Now()
 		monitor.Mutex.Lock()
 		monitor.ProcessIDs[processNumber] = -1
 		monitor.Mutex.Unlock()
 
 		processDuration := endTime.Sub(startTime)
-		if processDuration.Seconds() < errorBackoffSeconds {
-			logger.Info("Backing off from restarting subprocess", "backOffTimeSeconds", errorBackoffSeconds, "lastExecutionDurationSeconds", processDuration)
-			time.Sleep(errorBackoffSeconds * time.Second)
+		backoff, crashLooping := policy.RecordExit(processDuration, endTime, oomKilled)
+		if crashLooping {
+			logger.Info("Process has crashed repeatedly; entering crash loop", "crashLoopThreshold", crashLoopThreshold, "crashLoopWindow", crashLoopWindow)
+			err := monitor.PodClient.UpdateAnnotations(monitor)
+			if err != nil {
+				logger.Error(err, "Error updating pod annotations")
+			}
+			continue
+		}
+		if backoff > 0 {
+			logger.Info("Backing off from restarting subprocess", "backOffTime", backoff, "lastExecutionDuration", processDuration)
+			time.Sleep(backoff)
+		}
+	}
+}
+
+// probeUntilReady polls the subprocess's listening address for up to
+// minHealthyDuration. If the address accepts a connection in that time, it
+// resets the restart policy's backoff delay; otherwise it stops polling
+// and leaves the delay to RecordExit's duration check. This lets a
+// process that becomes ready quickly skip straight back to a fast restart
+// the next time it fails, rather than waiting out minHealthyDuration.
+func (monitor *Monitor) probeUntilReady(policy *RestartPolicy, arguments []string, logger logr.Logger) {
+	deadline := time.Now().Add(minHealthyDuration)
+	for time.Now().Before(deadline) {
+		if probeProcessReady(arguments) {
+			logger.Info("Subprocess passed readiness probe")
+			policy.RecordReady()
+			return
 		}
+		time.Sleep(readinessProbeTimeout)
 	}
 }
 
@@ -298,6 +572,10 @@ func (monitor *Monitor) Run() {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
+	controlSignals := make(chan os.Signal, 1)
+	signal.Notify(controlSignals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	go monitor.handleControlSignals(controlSignals)
+
 	go func() {
 		latestSignal := <-signals
 		monitor.Logger.Info("Received system signal", "signal", latestSignal)
@@ -332,10 +610,31 @@ func (monitor *Monitor) Run() {
 
 	defer watcher.Close()
 	go func() { monitor.WatchConfiguration(watcher) }()
+	go func() { monitor.PollConfiguration() }()
 
 	<-done
 }
 
+// PollConfiguration periodically polls monitor.ConfigFetcher for a new
+// configuration, reloading only when the reported etag has changed. This
+// runs alongside the fsnotify-based watch on ConfigFile, so a control
+// plane can push configuration through ConfigFetcher without needing a
+// projected volume update on every pod. It shares reloadConfiguration with
+// LoadConfiguration so the two paths cannot reapply the same configuration
+// twice between them.
+func (monitor *Monitor) PollConfiguration() {
+	if monitor.ConfigFetcher == nil {
+		return
+	}
+
+	ticker := time.NewTicker(monitor.ConfigPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		monitor.reloadConfiguration()
+	}
+}
+
 func (monitor *Monitor) WatchPodTimestamps() {
 	for timestamp := range monitor.PodClient.TimestampFeed {
 		if timestamp > monitor.LastConfigurationTime.Unix() {
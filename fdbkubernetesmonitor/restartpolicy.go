@@ -0,0 +1,243 @@
+// restartpolicy.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// initialBackoff is the restart delay after a healthy run or a passed
+// readiness probe. Each crash doubles the current delay, so the first
+// restart after a crash waits twice this long.
+const initialBackoff = 1 * time.Second
+
+// maxBackoff caps the exponential backoff delay between restarts.
+const maxBackoff = errorBackoffSeconds * time.Second
+
+// minHealthyDuration is how long a process must run before an exit is
+// treated as healthy rather than a crash.
+const minHealthyDuration = errorBackoffSeconds * time.Second
+
+// crashLoopWindow is the sliding window used to detect a crash loop.
+const crashLoopWindow = 5 * time.Minute
+
+// crashLoopThreshold is the number of crashes within crashLoopWindow that
+// trips a process into the crash-loop state.
+const crashLoopThreshold = 5
+
+// readinessProbeTimeout bounds how long a single readiness probe attempt
+// is allowed to take.
+const readinessProbeTimeout = 2 * time.Second
+
+// restartState describes where a supervised process sits in its restart
+// lifecycle, mirroring the Init/Started/Backoff/CrashLoop states used by
+// process supervisors like gonit.
+type restartState int
+
+const (
+	// restartStateInit means the process has not been started since the
+	// policy was created or last Reset.
+	restartStateInit restartState = iota
+
+	// restartStateStarted means the process is currently running.
+	restartStateStarted
+
+	// restartStateBackoff means the process exited and is waiting out an
+	// exponential backoff delay before its next restart.
+	restartStateBackoff
+
+	// restartStateCrashLoop means the process has crashed too many times
+	// within crashLoopWindow and restarts are suspended until RestartPolicy
+	// is explicitly reset.
+	restartStateCrashLoop
+)
+
+// String returns a human-readable name for the state, suitable for logging
+// and for exposing through pod annotations.
+func (state restartState) String() string {
+	switch state {
+	case restartStateInit:
+		return "Init"
+	case restartStateStarted:
+		return "Started"
+	case restartStateBackoff:
+		return "Backoff"
+	case restartStateCrashLoop:
+		return "CrashLoop"
+	default:
+		return "Unknown"
+	}
+}
+
+// RestartPolicy tracks the restart history for a single supervised process
+// and decides how RunProcess should react to an exit: restart immediately,
+// back off exponentially, or suspend restarts entirely because the process
+// is crash-looping.
+type RestartPolicy struct {
+	// mutex guards every field below.
+	mutex sync.Mutex
+
+	// state is the process's current position in the restart lifecycle.
+	state restartState
+
+	// backoff is the delay that will be used for the next restart.
+	backoff time.Duration
+
+	// failureTimes holds the timestamps of recent crashes, used to detect
+	// a crash loop over a sliding window.
+	failureTimes []time.Time
+
+	// release is closed when the crash-loop state is lifted, waking up any
+	// RunProcess goroutine blocked in WaitForRelease.
+	release chan struct{}
+}
+
+// NewRestartPolicy creates a RestartPolicy in its initial state.
+func NewRestartPolicy() *RestartPolicy {
+	return &RestartPolicy{
+		state:   restartStateInit,
+		backoff: initialBackoff,
+		release: make(chan struct{}),
+	}
+}
+
+// State returns the policy's current restart state.
+func (policy *RestartPolicy) State() restartState {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	return policy.state
+}
+
+// MarkStarted records that the process has started running.
+func (policy *RestartPolicy) MarkStarted() {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	policy.state = restartStateStarted
+}
+
+// RecordReady resets the backoff delay in response to a successful
+// readiness probe, without disturbing the crash-loop failure history.
+func (policy *RestartPolicy) RecordReady() {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	policy.backoff = initialBackoff
+}
+
+// RecordExit records that the process exited after running for duration.
+// It returns the restart decision: how long to back off, and whether the
+// process has now entered a crash loop. A duration at or above
+// minHealthyDuration is treated as a healthy run and resets the backoff
+// delay without counting as a crash, unless forceFailure is set (for
+// example because the process was killed by the kernel OOM killer), in
+// which case it always counts toward the crash-loop window.
+func (policy *RestartPolicy) RecordExit(duration time.Duration, now time.Time, forceFailure bool) (backoff time.Duration, crashLooping bool) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if duration >= minHealthyDuration && !forceFailure {
+		policy.backoff = initialBackoff
+		policy.state = restartStateBackoff
+		return policy.backoff, false
+	}
+
+	policy.failureTimes = append(policy.failureTimes, now)
+	cutoff := now.Add(-crashLoopWindow)
+	trimmed := policy.failureTimes[:0]
+	for _, failureTime := range policy.failureTimes {
+		if failureTime.After(cutoff) {
+			trimmed = append(trimmed, failureTime)
+		}
+	}
+	policy.failureTimes = trimmed
+
+	if len(policy.failureTimes) >= crashLoopThreshold {
+		policy.state = restartStateCrashLoop
+		return 0, true
+	}
+
+	policy.backoff *= 2
+	if policy.backoff > maxBackoff {
+		policy.backoff = maxBackoff
+	}
+	policy.state = restartStateBackoff
+
+	return policy.backoff, false
+}
+
+// WaitForRelease blocks until the crash-loop state is lifted by Reset.
+func (policy *RestartPolicy) WaitForRelease() {
+	policy.mutex.Lock()
+	release := policy.release
+	policy.mutex.Unlock()
+	<-release
+}
+
+// Reset clears the failure history, returns the backoff delay to
+// initialBackoff and the state to Init, whatever the current state. If the
+// process was crash-looping, goroutines blocked in WaitForRelease are
+// released. The monitor calls it for every process slot when it applies a
+// changed configuration; no signal handler calls it.
+func (policy *RestartPolicy) Reset() {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	policy.failureTimes = nil
+	policy.backoff = initialBackoff
+	if policy.state == restartStateCrashLoop {
+		close(policy.release)
+		policy.release = make(chan struct{})
+	}
+	policy.state = restartStateInit
+}
+
+// probeProcessReady attempts a single TCP connection to the listening
+// address that was passed to fdbserver in arguments, returning whether the
+// process accepted the connection within readinessProbeTimeout.
+func probeProcessReady(arguments []string) bool {
+	address, err := publicAddressFromArguments(arguments)
+	if err != nil {
+		return false
+	}
+
+	conn, err := net.DialTimeout("tcp", address, readinessProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// publicAddressFromArguments extracts the host:port that fdbserver was
+// told to listen on from its --public_address flag.
+func publicAddressFromArguments(arguments []string) (string, error) {
+	for index, argument := range arguments {
+		if argument == "--public_address" && index+1 < len(arguments) {
+			// The flag value may carry a ":tls" suffix to request TLS on
+			// that listener; strip it for the purposes of a plain probe.
+			address := strings.TrimSuffix(arguments[index+1], ":tls")
+			return address, nil
+		}
+	}
+	return "", fmt.Errorf("no --public_address flag found in arguments")
+}
@@ -0,0 +1,206 @@
+// restartpolicy_test.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordExitHealthyRunResetsBackoff(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	policy.RecordExit(1*time.Second, now, false)
+	backoff, crashLooping := policy.RecordExit(minHealthyDuration, now.Add(time.Hour), false)
+
+	if crashLooping {
+		t.Fatalf("expected a healthy-duration exit not to trigger a crash loop")
+	}
+	if backoff != initialBackoff {
+		t.Fatalf("expected backoff to reset to %s after a healthy run, got %s", initialBackoff, backoff)
+	}
+	if policy.State() != restartStateBackoff {
+		t.Fatalf("expected state Backoff after an exit, got %s", policy.State())
+	}
+}
+
+func TestRecordExitDoublesBackoffOnQuickCrash(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	first, _ := policy.RecordExit(1*time.Second, now, false)
+	second, _ := policy.RecordExit(1*time.Second, now.Add(time.Second), false)
+
+	if first != initialBackoff*2 {
+		t.Fatalf("expected first backoff to double to %s, got %s", initialBackoff*2, first)
+	}
+	if second != initialBackoff*4 {
+		t.Fatalf("expected second backoff to double to %s, got %s", initialBackoff*4, second)
+	}
+}
+
+func TestRecordExitBackoffIsCapped(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	// Space crashes further apart than crashLoopWindow so each one ages out
+	// the last before the next is recorded; that keeps the crash count
+	// under crashLoopThreshold indefinitely while still exercising the
+	// exponential backoff growth on every single exit.
+	var backoff time.Duration
+	for i := 0; i < 20; i++ {
+		backoff, _ = policy.RecordExit(1*time.Second, now.Add(time.Duration(i)*(crashLoopWindow+time.Second)), false)
+		if backoff >= maxBackoff {
+			break
+		}
+	}
+
+	if backoff != maxBackoff {
+		t.Fatalf("expected backoff to be capped at %s, got %s", maxBackoff, backoff)
+	}
+	if policy.State() == restartStateCrashLoop {
+		t.Fatalf("expected crashes spaced outside crashLoopWindow not to trip a crash loop")
+	}
+}
+
+func TestRecordExitTripsCrashLoopAfterThreshold(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	var crashLooping bool
+	for i := 0; i < crashLoopThreshold; i++ {
+		_, crashLooping = policy.RecordExit(1*time.Second, now.Add(time.Duration(i)*time.Second), false)
+	}
+
+	if !crashLooping {
+		t.Fatalf("expected crashLoopThreshold crashes within the window to trip a crash loop")
+	}
+	if policy.State() != restartStateCrashLoop {
+		t.Fatalf("expected state CrashLoop, got %s", policy.State())
+	}
+}
+
+func TestRecordExitIgnoresCrashesOutsideWindow(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	for i := 0; i < crashLoopThreshold-1; i++ {
+		policy.RecordExit(1*time.Second, now.Add(time.Duration(i)*time.Second), false)
+	}
+
+	_, crashLooping := policy.RecordExit(1*time.Second, now.Add(crashLoopWindow+time.Minute), false)
+
+	if crashLooping {
+		t.Fatalf("expected crashes outside crashLoopWindow not to count toward the threshold")
+	}
+}
+
+func TestRecordExitForceFailureCountsDespiteHealthyDuration(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	backoff, crashLooping := policy.RecordExit(minHealthyDuration, now, true)
+
+	if crashLooping {
+		t.Fatalf("a single force-failure exit should not trip a crash loop by itself")
+	}
+	if backoff != initialBackoff*2 {
+		t.Fatalf("expected a forced-failure exit to double the backoff to %s like a crash, got %s", initialBackoff*2, backoff)
+	}
+	if policy.State() != restartStateBackoff {
+		t.Fatalf("expected state Backoff after a forced-failure exit, got %s", policy.State())
+	}
+}
+
+func TestResetClearsCrashLoopAndReleasesWaiters(t *testing.T) {
+	policy := NewRestartPolicy()
+	now := time.Now()
+
+	for i := 0; i < crashLoopThreshold; i++ {
+		policy.RecordExit(1*time.Second, now.Add(time.Duration(i)*time.Second), false)
+	}
+	if policy.State() != restartStateCrashLoop {
+		t.Fatalf("expected the policy to be crash-looping before Reset")
+	}
+
+	waiting := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		close(waiting)
+		policy.WaitForRelease()
+		close(released)
+	}()
+
+	<-waiting
+	// Give the goroutine a moment to enter WaitForRelease and capture the
+	// release channel before Reset swaps it out from under a late reader.
+	time.Sleep(10 * time.Millisecond)
+	policy.Reset()
+
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Reset to release a goroutine blocked in WaitForRelease")
+	}
+
+	if policy.State() != restartStateInit {
+		t.Fatalf("expected state Init after Reset, got %s", policy.State())
+	}
+}
+
+func TestPublicAddressFromArguments(t *testing.T) {
+	tests := map[string]struct {
+		arguments []string
+		address   string
+		wantErr   bool
+	}{
+		"plain address": {
+			arguments: []string{"fdbserver", "--public_address", "127.0.0.1:4500"},
+			address:   "127.0.0.1:4500",
+		},
+		"tls suffix is stripped": {
+			arguments: []string{"fdbserver", "--public_address", "127.0.0.1:4500:tls"},
+			address:   "127.0.0.1:4500",
+		},
+		"missing flag": {
+			arguments: []string{"fdbserver"},
+			wantErr:   true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			address, err := publicAddressFromArguments(test.arguments)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got address %q", address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if address != test.address {
+				t.Fatalf("expected address %q, got %q", test.address, address)
+			}
+		})
+	}
+}
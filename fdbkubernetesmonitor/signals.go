@@ -0,0 +1,201 @@
+// signals.go
+//
+// This source file is part of the FoundationDB open source project
+//
+// Copyright 2021 Apple Inc. and the FoundationDB project authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// rollingRestartHealthCheckInterval is how often a SIGUSR1-triggered
+// rolling restart polls a process to see if it has come back up.
+const rollingRestartHealthCheckInterval = 1 * time.Second
+
+// rollingRestartHealthTimeout bounds how long a rolling restart waits for
+// a process to come back up before moving on to the next one anyway.
+const rollingRestartHealthTimeout = 5 * time.Minute
+
+// handleControlSignals services the out-of-band operator signals
+// (SIGHUP/SIGUSR1/SIGUSR2) for the lifetime of the monitor, independently
+// of the SIGINT/SIGTERM shutdown path.
+func (monitor *Monitor) handleControlSignals(signals <-chan os.Signal) {
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGHUP:
+			monitor.Logger.Info("Received SIGHUP; reloading configuration immediately")
+			monitor.LoadConfiguration()
+
+		case syscall.SIGUSR1:
+			monitor.Mutex.Lock()
+			alreadyRunning := monitor.RollingRestartActive
+			if !alreadyRunning {
+				monitor.RollingRestartActive = true
+			}
+			monitor.Mutex.Unlock()
+
+			if alreadyRunning {
+				monitor.Logger.Info("Received SIGUSR1; ignoring because a rolling restart is already in progress")
+				continue
+			}
+			monitor.Logger.Info("Received SIGUSR1; starting drained rolling restart")
+			go monitor.RollingRestart()
+
+		case syscall.SIGUSR2:
+			monitor.Mutex.Lock()
+			paused := !monitor.Paused
+			monitor.Mutex.Unlock()
+			monitor.Logger.Info("Received SIGUSR2; toggling paused mode", "paused", paused)
+			monitor.SetPaused(paused)
+			err := monitor.PodClient.UpdateAnnotations(monitor)
+			if err != nil {
+				monitor.Logger.Error(err, "Error updating pod annotations")
+			}
+		}
+	}
+}
+
+// SetPaused puts the monitor into, or takes it out of, paused mode. While
+// paused, RunProcess will not restart a process after it exits, which is
+// useful for debugging a crashing fdbserver without the monitor
+// immediately starting another copy.
+func (monitor *Monitor) SetPaused(paused bool) {
+	monitor.Mutex.Lock()
+	defer monitor.Mutex.Unlock()
+
+	if monitor.Paused == paused {
+		return
+	}
+	monitor.Paused = paused
+	if !paused {
+		close(monitor.unpauseSignal)
+		monitor.unpauseSignal = make(chan struct{})
+	}
+}
+
+// waitWhilePaused blocks for as long as the monitor is in paused mode.
+func (monitor *Monitor) waitWhilePaused(logger logr.Logger) {
+	for {
+		monitor.Mutex.Lock()
+		if !monitor.Paused {
+			monitor.Mutex.Unlock()
+			return
+		}
+		signal := monitor.unpauseSignal
+		monitor.Mutex.Unlock()
+
+		logger.Info("Monitor is paused; not restarting subprocess")
+		<-signal
+	}
+}
+
+// RollingRestart performs a drained rolling restart of every active
+// process, stopping and waiting for each one to come back up before moving
+// on to the next. A process that does not come back within
+// rollingRestartHealthTimeout is left behind and the restart moves on, so
+// more than one process can then be down at the same time.
+func (monitor *Monitor) RollingRestart() {
+	monitor.Mutex.Lock()
+	serverCount := 0
+	if monitor.ActiveConfiguration != nil {
+		serverCount = monitor.ActiveConfiguration.ServerCount
+	}
+	monitor.RollingRestartActive = true
+	monitor.Mutex.Unlock()
+
+	defer func() {
+		monitor.Mutex.Lock()
+		monitor.RollingRestartActive = false
+		monitor.RollingRestartProcessNumber = 0
+		monitor.Mutex.Unlock()
+		err := monitor.PodClient.UpdateAnnotations(monitor)
+		if err != nil {
+			monitor.Logger.Error(err, "Error updating pod annotations")
+		}
+	}()
+
+	for processNumber := 1; processNumber <= serverCount; processNumber++ {
+		logger := monitor.Logger.WithValues("processNumber", processNumber, "area", "RollingRestart")
+
+		monitor.Mutex.Lock()
+		monitor.RollingRestartProcessNumber = processNumber
+		pid := monitor.ProcessIDs[processNumber]
+		monitor.Mutex.Unlock()
+
+		err := monitor.PodClient.UpdateAnnotations(monitor)
+		if err != nil {
+			logger.Error(err, "Error updating pod annotations")
+		}
+
+		if pid <= 0 {
+			logger.Info("Skipping rolling restart for process with no running subprocess")
+			continue
+		}
+
+		logger.Info("Rolling restart: stopping subprocess", "PID", pid)
+		err = signalProcess(pid)
+		if err != nil {
+			logger.Error(err, "Error stopping subprocess for rolling restart", "PID", pid)
+			continue
+		}
+
+		monitor.waitForHealthy(processNumber, pid, logger)
+	}
+}
+
+// waitForHealthy blocks until the process in slot processNumber has both
+// restarted (its PID is positive and differs from previousPID) and passed a
+// readiness probe, or until
+// rollingRestartHealthTimeout elapses. A bare check that the PID is
+// nonzero would pass the instant RunProcess forks the replacement, well
+// before fdbserver is actually serving, reopening the simultaneous-
+// downtime window a drained rolling restart exists to close.
+func (monitor *Monitor) waitForHealthy(processNumber int, previousPID int, logger logr.Logger) {
+	deadline := time.Now().Add(rollingRestartHealthTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(rollingRestartHealthCheckInterval)
+
+		monitor.Mutex.Lock()
+		pid := monitor.ProcessIDs[processNumber]
+		var arguments []string
+		var err error
+		if pid > 0 && monitor.ActiveConfiguration != nil {
+			arguments, err = monitor.ActiveConfiguration.GenerateArguments(processNumber, nil)
+		}
+		monitor.Mutex.Unlock()
+
+		if pid <= 0 || pid == previousPID {
+			continue
+		}
+		if err != nil {
+			logger.Error(err, "Error generating arguments to probe subprocess readiness")
+			continue
+		}
+
+		if probeProcessReady(arguments) {
+			logger.Info("Rolling restart: subprocess is healthy again", "PID", pid)
+			return
+		}
+	}
+
+	logger.Info("Rolling restart: subprocess did not become healthy before timeout; continuing to next process")
+}